@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	clients "github.com/mkihr/pvc-autoscaler/internal/metrics_clients/clients"
+	"github.com/mkihr/pvc-autoscaler/internal/telemetry"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// metricsCacheTTL bounds how long a cluster-wide metrics fetch is reused
+// across reconciles. Workers dequeue one PVC key at a time, but the
+// informer enqueues every annotated PVC individually on startup and on
+// every resync, so without this a burst of N queued keys would otherwise
+// turn into N full cluster-wide metrics fetches instead of sharing one.
+const metricsCacheTTL = 10 * time.Second
+
+// metricsCache holds the most recent cluster-wide metrics fetch, reused by
+// reconciles that land within metricsCacheTTL of it.
+type metricsCache struct {
+	mu      sync.Mutex
+	metrics map[types.NamespacedName]*clients.PVCMetrics
+	fetched time.Time
+}
+
+// setupInformer wires a SharedInformerFactory watching PersistentVolumeClaims
+// into pvcAutoscaler's workqueue: Add/Update events for PVCs carrying the
+// enabled annotation are enqueued immediately, and the factory's periodic
+// resync re-enqueues every tracked PVC so metrics get refreshed even when
+// nothing changed.
+func (p *PVCAutoscaler) setupInformer(resyncPeriod time.Duration, workers int) {
+	p.queue = workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	p.workers = workers
+	p.informerFactory = informers.NewSharedInformerFactory(p.kubeClient, resyncPeriod)
+
+	pvcInformer := p.informerFactory.Core().V1().PersistentVolumeClaims().Informer()
+	pvcInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    p.enqueueIfEnabled,
+		UpdateFunc: func(_, newObj interface{}) { p.enqueueIfEnabled(newObj) },
+	})
+	p.informer = pvcInformer
+}
+
+func (p *PVCAutoscaler) enqueueIfEnabled(obj interface{}) {
+	pvc, ok := obj.(*corev1.PersistentVolumeClaim)
+	if !ok {
+		return
+	}
+	if pvc.Annotations[PVCAutoscalerEnabledAnnotation] != "true" {
+		return
+	}
+
+	key, err := cache.MetaNamespaceKeyFunc(pvc)
+	if err != nil {
+		p.logger.Errorf("failed to compute key for pvc %s/%s: %v", pvc.Namespace, pvc.Name, err)
+		return
+	}
+	p.queue.Add(key)
+}
+
+// Run starts the informer, waits for the initial cache sync, then starts
+// p.workers worker goroutines that pop keys off the queue and reconcile
+// them one at a time. It blocks until ctx is cancelled.
+func (p *PVCAutoscaler) Run(ctx context.Context) error {
+	defer p.queue.ShutDown()
+
+	p.informerFactory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), p.informer.HasSynced) {
+		return fmt.Errorf("failed to sync PVC informer cache")
+	}
+
+	for i := 0; i < p.workers; i++ {
+		go wait.Until(p.runWorker, time.Second, ctx.Done())
+	}
+
+	<-ctx.Done()
+	return nil
+}
+
+func (p *PVCAutoscaler) runWorker() {
+	for p.processNextItem() {
+	}
+}
+
+func (p *PVCAutoscaler) processNextItem() bool {
+	key, shutdown := p.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer p.queue.Done(key)
+
+	if err := p.reconcileKey(key.(string)); err != nil {
+		p.logger.Errorf("failed to reconcile %s: %v", key, err)
+		p.queue.AddRateLimited(key)
+		return true
+	}
+
+	p.queue.Forget(key)
+	return true
+}
+
+func (p *PVCAutoscaler) reconcileKey(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), p.reconcileTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := p.reconcile(ctx, key)
+	telemetry.ReconcileDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		telemetry.ReconcileTotal.WithLabelValues("error").Inc()
+		return err
+	}
+	telemetry.ReconcileTotal.WithLabelValues("success").Inc()
+	return nil
+}
+
+// fetchPVCsMetrics returns the most recent cluster-wide metrics fetch if
+// it's younger than metricsCacheTTL, otherwise it fetches a fresh one. This
+// lets a burst of queued PVC keys (e.g. the initial informer sync) share a
+// single cluster-wide metrics query instead of one per key.
+func (p *PVCAutoscaler) fetchPVCsMetrics(ctx context.Context) (map[types.NamespacedName]*clients.PVCMetrics, error) {
+	p.metricsCache.mu.Lock()
+	defer p.metricsCache.mu.Unlock()
+
+	if p.metricsCache.metrics != nil && time.Since(p.metricsCache.fetched) < metricsCacheTTL {
+		return p.metricsCache.metrics, nil
+	}
+
+	metrics, err := p.metricsClient.FetchPVCsMetrics(ctx, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	p.metricsCache.metrics = metrics
+	p.metricsCache.fetched = time.Now()
+	return metrics, nil
+}
+
+// reconcile fetches the latest metrics for the single PVC identified by key
+// and logs its usage ratio. It does not read the threshold/ceiling/increase
+// annotations and does not resize the PVC.
+func (p *PVCAutoscaler) reconcile(ctx context.Context, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return fmt.Errorf("invalid resource key %q: %w", key, err)
+	}
+
+	metrics, err := p.fetchPVCsMetrics(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to fetch PVC metrics: %w", err)
+	}
+
+	pvcMetrics, ok := metrics[types.NamespacedName{Namespace: namespace, Name: name}]
+	if !ok {
+		p.logger.Warnf("no metrics found for pvc %s/%s", namespace, name)
+		return nil
+	}
+
+	if pvcMetrics.VolumeCapacityBytes == 0 {
+		p.logger.Warnf("pvc %s/%s reported zero capacity, skipping", namespace, name)
+		return nil
+	}
+
+	ratio := float64(pvcMetrics.VolumeUsedBytes) / float64(pvcMetrics.VolumeCapacityBytes)
+	p.logger.Debugf("pvc %s/%s usage ratio: %.2f", namespace, name, ratio)
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mkihr/pvc-autoscaler/internal/logger"
+	clients "github.com/mkihr/pvc-autoscaler/internal/metrics_clients/clients"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func init() {
+	logger.Init(log.InfoLevel)
+}
+
+func newTestAutoscaler() *PVCAutoscaler {
+	return &PVCAutoscaler{
+		logger:           logger.Logger,
+		reconcileTimeout: time.Second,
+		queue:            workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+	}
+}
+
+func TestEnqueueIfEnabled(t *testing.T) {
+	t.Run("enqueues an annotated pvc", func(t *testing.T) {
+		p := newTestAutoscaler()
+		pvc := &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:   "default",
+				Name:        "data",
+				Annotations: map[string]string{PVCAutoscalerEnabledAnnotation: "true"},
+			},
+		}
+
+		p.enqueueIfEnabled(pvc)
+
+		assert.Equal(t, 1, p.queue.Len())
+		key, _ := p.queue.Get()
+		assert.Equal(t, "default/data", key)
+	})
+
+	t.Run("ignores a pvc without the enabled annotation", func(t *testing.T) {
+		p := newTestAutoscaler()
+		pvc := &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "data"},
+		}
+
+		p.enqueueIfEnabled(pvc)
+
+		assert.Equal(t, 0, p.queue.Len())
+	})
+
+	t.Run("ignores objects that aren't PVCs", func(t *testing.T) {
+		p := newTestAutoscaler()
+
+		p.enqueueIfEnabled("not-a-pvc")
+
+		assert.Equal(t, 0, p.queue.Len())
+	})
+}
+
+// fakeMetricsClient is a clients.MetricsClient stub that counts how many
+// times it was queried, so tests can assert on fetch/caching behavior
+// without spinning up an httptest server.
+type fakeMetricsClient struct {
+	metrics map[types.NamespacedName]*clients.PVCMetrics
+	err     error
+	calls   int
+}
+
+func (f *fakeMetricsClient) FetchPVCsMetrics(_ context.Context, _ time.Time) (map[types.NamespacedName]*clients.PVCMetrics, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.metrics, nil
+}
+
+func TestProcessNextItem(t *testing.T) {
+	t.Run("forgets the key on success", func(t *testing.T) {
+		p := newTestAutoscaler()
+		p.metricsClient = &fakeMetricsClient{metrics: map[types.NamespacedName]*clients.PVCMetrics{
+			{Namespace: "default", Name: "data"}: {VolumeUsedBytes: 1, VolumeCapacityBytes: 2},
+		}}
+		p.queue.Add("default/data")
+
+		assert.True(t, p.processNextItem())
+		assert.Equal(t, 0, p.queue.Len())
+		assert.Equal(t, 0, p.queue.NumRequeues("default/data"))
+	})
+
+	t.Run("rate-limit requeues the key on error", func(t *testing.T) {
+		p := newTestAutoscaler()
+		p.metricsClient = &fakeMetricsClient{err: errors.New("fetch failed")}
+		p.queue.Add("default/data")
+
+		assert.True(t, p.processNextItem())
+		assert.Equal(t, 1, p.queue.NumRequeues("default/data"))
+	})
+}
+
+func TestFetchPVCsMetricsCachesWithinTTL(t *testing.T) {
+	p := newTestAutoscaler()
+	fake := &fakeMetricsClient{metrics: map[types.NamespacedName]*clients.PVCMetrics{}}
+	p.metricsClient = fake
+
+	_, err := p.fetchPVCsMetrics(context.Background())
+	assert.NoError(t, err)
+	_, err = p.fetchPVCsMetrics(context.Background())
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1, fake.calls)
+}
@@ -2,14 +2,21 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"net/http"
 	"strings"
 	"time"
 
 	"github.com/mkihr/pvc-autoscaler/internal/logger"
 	clients "github.com/mkihr/pvc-autoscaler/internal/metrics_clients/clients"
+	"github.com/mkihr/pvc-autoscaler/internal/metrics_clients/prometheus"
+	"github.com/mkihr/pvc-autoscaler/internal/telemetry"
 	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
 )
 
 const (
@@ -23,10 +30,14 @@ const (
 	DefaultThreshold = "80%"
 	DefaultIncrease  = "20%"
 
-	DefaultReconcileTimeOut = 1 * time.Minute
-	DefaultPollingInterval  = 30 * time.Second
-	DefaultLogLevel         = "INFO"
-	DefaultMetricsProvider  = "prometheus"
+	DefaultReconcileTimeOut   = 1 * time.Minute
+	DefaultPollingInterval    = 30 * time.Second
+	DefaultLogLevel           = "INFO"
+	DefaultMetricsProvider    = "prometheus"
+	DefaultTLSProfile         = prometheus.TLSProfileDefault
+	DefaultMetricsBindAddress = ":8080"
+	DefaultWorkers            = 2
+	DefaultBearerTokenTTL     = 1 * time.Minute
 )
 
 type PVCAutoscaler struct {
@@ -34,17 +45,34 @@ type PVCAutoscaler struct {
 	metricsClient        clients.MetricsClient
 	logger               *log.Logger
 	pollingInterval      time.Duration
+	reconcileTimeout     time.Duration
 	pvcsWithMetricsError map[string]bool
+
+	queue           workqueue.RateLimitingInterface
+	informerFactory informers.SharedInformerFactory
+	informer        cache.SharedIndexInformer
+	workers         int
+
+	metricsCache metricsCache
 }
 
 func main() {
 	metricsClient := flag.String("metrics-client", DefaultMetricsProvider, "specify the metrics client to use to query volume stats")
-	metricsClientURL := flag.String("metrics-client-url", "", "Specify the metrics client URL to use to query volume stats")
+	metricsClientURL := flag.String("metrics-client-url", "", "specify the metrics client URL(s) to use to query volume stats; comma-separate multiple URLs for round-robin load balancing and failover")
 	pollingInterval := flag.Duration("polling-interval", DefaultPollingInterval, "specify how often to check pvc stats")
 	reconcileTimeout := flag.Duration("reconcile-timeout", DefaultReconcileTimeOut, "specify the time after which the reconciliation is considered failed")
 	logLevel := flag.String("log-level", DefaultLogLevel, "specify the log level")
 	insecureSkipVerify := flag.Bool("insecure-skip-verify", false, "skip TLS certificate verification when connecting to metrics")
 	bearerTokenFile := flag.String("bearer-token-file", "", "path to bearer token file for Prometheus authentication (e.g., /var/run/secrets/kubernetes.io/serviceaccount/token)")
+	bearerTokenTTL := flag.Duration("bearer-token-ttl", DefaultBearerTokenTTL, "how long to cache the bearer token from --bearer-token-file before re-reading it from disk")
+	tlsProfile := flag.String("tls-profile", string(DefaultTLSProfile), "TLS profile to use when connecting to metrics over HTTPS: secure, default, or legacy")
+	caFile := flag.String("ca-file", "", "path to a PEM-encoded CA certificate to trust in addition to the system roots when connecting to metrics")
+	serverName := flag.String("server-name", "", "override the TLS server name used to verify the metrics endpoint's certificate")
+	clientCertFile := flag.String("client-cert-file", "", "path to a PEM-encoded client certificate for mTLS authentication to metrics")
+	clientKeyFile := flag.String("client-key-file", "", "path to the PEM-encoded private key matching --client-cert-file")
+	certReloadInterval := flag.Duration("cert-reload-interval", 0, "how long to cache the client certificate from --client-cert-file/--client-key-file before re-reading it from disk (0 reloads on every handshake)")
+	metricsBindAddress := flag.String("metrics-bind-address", DefaultMetricsBindAddress, "address to bind the /metrics, /healthz and /readyz HTTP endpoints to")
+	workers := flag.Int("workers", DefaultWorkers, "number of worker goroutines reconciling PVCs concurrently")
 
 	flag.Parse()
 
@@ -72,7 +100,18 @@ func main() {
 	}
 	logger.Logger.Info("kubernetes client ready")
 
-	PVCMetricsClient, err := MetricsClientFactory(*metricsClient, *metricsClientURL, *insecureSkipVerify, *bearerTokenFile)
+	PVCMetricsClient, err := MetricsClientFactory(*metricsClient, MetricsClientOptions{
+		URL:                *metricsClientURL,
+		InsecureSkipVerify: *insecureSkipVerify,
+		BearerTokenFile:    *bearerTokenFile,
+		BearerTokenTTL:     *bearerTokenTTL,
+		TLSProfile:         prometheus.TLSProfile(*tlsProfile),
+		CAFile:             *caFile,
+		ServerName:         *serverName,
+		ClientCertFile:     *clientCertFile,
+		ClientKeyFile:      *clientKeyFile,
+		CertReloadInterval: *certReloadInterval,
+	})
 	if err != nil {
 		logger.Logger.Fatalf("metrics client error: %s", err)
 	}
@@ -84,22 +123,22 @@ func main() {
 		metricsClient:        PVCMetricsClient,
 		logger:               logger.Logger,
 		pollingInterval:      *pollingInterval,
+		reconcileTimeout:     *reconcileTimeout,
 		pvcsWithMetricsError: make(map[string]bool),
 	}
+	pvcAutoscaler.setupInformer(*pollingInterval, *workers)
 
-	logger.Logger.Info("pvc-autoscaler ready")
-
-	ticker := time.NewTicker(pvcAutoscaler.pollingInterval)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		ctx, cancel := context.WithTimeout(context.Background(), *reconcileTimeout)
-
-		err := pvcAutoscaler.reconcile(ctx)
-		if err != nil {
-			pvcAutoscaler.logger.Errorf("failed to reconcile: %v", err)
+	metricsServer := telemetry.NewServer(*metricsBindAddress)
+	go func() {
+		logger.Logger.Infof("metrics server listening on %s", *metricsBindAddress)
+		if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Logger.Fatalf("metrics server error: %s", err)
 		}
+	}()
+
+	logger.Logger.Info("pvc-autoscaler ready")
 
-		cancel()
+	if err := pvcAutoscaler.Run(context.Background()); err != nil {
+		logger.Logger.Fatalf("controller exited: %s", err)
 	}
 }
@@ -2,20 +2,53 @@ package main
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	clients "github.com/mkihr/pvc-autoscaler/internal/metrics_clients/clients"
 	"github.com/mkihr/pvc-autoscaler/internal/metrics_clients/prometheus"
+	"github.com/mkihr/pvc-autoscaler/internal/metrics_clients/thanos"
 )
 
-func MetricsClientFactory(clientName, clientUrl string, insecureSkipVerify bool) (clients.MetricsClient, error) {
+// registeredMetricsClients lists the valid values for --metrics-client, used
+// to build a helpful error message when an unknown one is passed.
+var registeredMetricsClients = []string{"prometheus", "thanos"}
+
+// MetricsClientOptions collects the settings needed to build any of the
+// supported metrics clients. Not every backend uses every field.
+type MetricsClientOptions struct {
+	URL                string
+	InsecureSkipVerify bool
+	BearerTokenFile    string
+	BearerTokenTTL     time.Duration
+	TLSProfile         prometheus.TLSProfile
+	CAFile             string
+	ServerName         string
+	ClientCertFile     string
+	ClientKeyFile      string
+	CertReloadInterval time.Duration
+}
+
+func MetricsClientFactory(clientName string, opts MetricsClientOptions) (clients.MetricsClient, error) {
+	cfg := prometheus.Config{
+		URL:                opts.URL,
+		InsecureSkipVerify: opts.InsecureSkipVerify,
+		BearerTokenFile:    opts.BearerTokenFile,
+		BearerTokenTTL:     opts.BearerTokenTTL,
+		TLSProfile:         opts.TLSProfile,
+		CAFile:             opts.CAFile,
+		ServerName:         opts.ServerName,
+		ClientCertFile:     opts.ClientCertFile,
+		ClientKeyFile:      opts.ClientKeyFile,
+		CertReloadInterval: opts.CertReloadInterval,
+	}
+
 	switch clientName {
-    case "prometheus":
-        prometheusClient, err := prometheus.NewPrometheusClient(clientUrl, insecureSkipVerify)
-        if err != nil {
-            return nil, err
-        }
-        return prometheusClient, nil
-    default:
-        return nil, fmt.Errorf("unknown metrics client: %s", clientName)
-    }
+	case "prometheus":
+		return prometheus.NewPrometheusClient(cfg)
+	case "thanos":
+		return thanos.NewThanosClient(cfg)
+	default:
+		return nil, fmt.Errorf("unknown metrics client: %s (registered clients: %s)", clientName, strings.Join(registeredMetricsClients, ", "))
+	}
 }
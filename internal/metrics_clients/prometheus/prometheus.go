@@ -3,81 +3,397 @@ package prometheus
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/mkihr/pvc-autoscaler/internal/logger"
 	clients "github.com/mkihr/pvc-autoscaler/internal/metrics_clients/clients"
+	"github.com/mkihr/pvc-autoscaler/internal/telemetry"
 	prometheusApi "github.com/prometheus/client_golang/api"
 	prometheusv1 "github.com/prometheus/client_golang/api/prometheus/v1"
 	"github.com/prometheus/common/model"
 	"k8s.io/apimachinery/pkg/types"
 )
 
+// defaultClientName identifies this backend in telemetry labels when
+// Config.ClientName isn't set. Backends built on top of this client (e.g.
+// Thanos) override it so their telemetry doesn't masquerade as plain
+// Prometheus.
+const defaultClientName = "prometheus"
+
 const (
 	usedBytesQuery     = "kubelet_volume_stats_used_bytes"
 	capacityBytesQuery = "kubelet_volume_stats_capacity_bytes"
+
+	// defaultBearerTokenTTL bounds how long a cached bearer token is trusted
+	// before it is re-read from disk, when Config.BearerTokenTTL isn't set.
+	// The file's mtime is not checked; only this TTL triggers a re-read.
+	defaultBearerTokenTTL = 1 * time.Minute
+
+	// endpointRetryBaseDelay is the initial delay between retries against
+	// successive endpoints, doubling on each subsequent attempt.
+	endpointRetryBaseDelay = 100 * time.Millisecond
+)
+
+// TLSProfile selects a curated set of TLS parameters, mirroring the tiered
+// profiles used by hardened Kubernetes components (kube-apiserver's
+// --tls-min-version/--tls-cipher-suites).
+type TLSProfile string
+
+const (
+	// TLSProfileSecure allows TLS 1.3 only.
+	TLSProfileSecure TLSProfile = "secure"
+	// TLSProfileDefault allows TLS 1.2+ with a curated list of ECDHE
+	// ciphers using AES-GCM and CHACHA20.
+	TLSProfileDefault TLSProfile = "default"
+	// TLSProfileLegacy allows TLS 1.2+ with a broader cipher set, for
+	// endpoints that can't be upgraded to the default profile.
+	TLSProfileLegacy TLSProfile = "legacy"
 )
 
+var tlsProfileCipherSuites = map[TLSProfile][]uint16{
+	TLSProfileDefault: {
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	},
+	TLSProfileLegacy: {
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+		tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+	},
+}
+
+// tlsConfig builds the *tls.Config for the profile. skipVerify and a
+// resolved RootCAs pool are applied on top since they're independent of
+// the profile itself.
+func (p TLSProfile) tlsConfig() (*tls.Config, error) {
+	switch p {
+	case TLSProfileSecure:
+		return &tls.Config{MinVersion: tls.VersionTLS13}, nil
+	case TLSProfileLegacy:
+		return &tls.Config{
+			MinVersion:       tls.VersionTLS12,
+			CipherSuites:     tlsProfileCipherSuites[TLSProfileLegacy],
+			CurvePreferences: []tls.CurveID{tls.CurveP256, tls.X25519, tls.CurveP384},
+		}, nil
+	case "", TLSProfileDefault:
+		return &tls.Config{
+			MinVersion:       tls.VersionTLS12,
+			CipherSuites:     tlsProfileCipherSuites[TLSProfileDefault],
+			CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown TLS profile: %s", p)
+	}
+}
+
+// Config holds the parameters needed to build a PrometheusClient. It's
+// grown beyond what's comfortable as positional NewPrometheusClient
+// arguments, so callers assemble one of these instead.
+type Config struct {
+	URL                string
+	InsecureSkipVerify bool
+	BearerTokenFile    string
+	// BearerTokenTTL overrides how long a cached bearer token is trusted
+	// before it's re-read from disk. Zero means defaultBearerTokenTTL.
+	BearerTokenTTL time.Duration
+	TLSProfile     TLSProfile
+	CAFile         string
+	ServerName     string
+
+	// ClientName identifies this backend in telemetry labels (e.g.
+	// "prometheus", "thanos"). Empty means defaultClientName.
+	ClientName string
+
+	// ClientCertFile/ClientKeyFile, when both set, enable mTLS: the
+	// keypair is presented on every TLS handshake and reloaded from disk
+	// so a rotated certificate is picked up without a restart.
+	ClientCertFile string
+	ClientKeyFile  string
+	// CertReloadInterval caches the loaded keypair for this long before
+	// re-reading it from disk. Zero means reload on every handshake.
+	CertReloadInterval time.Duration
+
+	// ExtraQueryParams is appended to the query string of every request,
+	// letting other backends built on top of this client (e.g. a Thanos
+	// querier wanting partial_response=false) reuse its auth/TLS handling.
+	ExtraQueryParams map[string]string
+}
+
+// endpoint pairs a metrics backend URL with the v1 API client built for it.
+type endpoint struct {
+	url string
+	api prometheusv1.API
+	// healthy is 1 if the last query against this endpoint succeeded (or
+	// none has been tried yet), 0 if it failed. Endpoints marked unhealthy
+	// are skipped on subsequent round-robin picks until every endpoint is
+	// unhealthy, so a known-bad endpoint in the rotation isn't retried on
+	// every single call.
+	healthy int32
+}
+
+// PrometheusClient queries one or more Prometheus/Thanos-compatible
+// endpoints, round-robining between them and failing over to the next one
+// on a server error or transport failure.
 type PrometheusClient struct {
-	prometheusAPI prometheusv1.API
+	endpoints []*endpoint
+	// next is the round-robin cursor into endpoints, advanced atomically by
+	// exactly 1 on each query() call.
+	next uint64
+	// clientName identifies this backend in telemetry labels.
+	clientName string
+
+	// bearerTokenRT is non-nil when bearer token authentication is in use.
+	// It is kept around so tests can force a token refresh.
+	bearerTokenRT *bearerTokenRoundTripper
+	// clientCertReloader is non-nil when mTLS client-certificate
+	// authentication is in use. It is kept around so tests can force a
+	// certificate refresh.
+	clientCertReloader *clientCertReloader
+}
+
+// queryParamRoundTripper adds a fixed set of query parameters to every
+// outgoing request, used to pass Thanos-specific query options through.
+type queryParamRoundTripper struct {
+	params map[string]string
+	rt     http.RoundTripper
+}
+
+func (q *queryParamRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(q.params) == 0 {
+		return q.rt.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	query := req.URL.Query()
+	for k, v := range q.params {
+		query.Set(k, v)
+	}
+	req.URL.RawQuery = query.Encode()
+	return q.rt.RoundTrip(req)
 }
 
-// bearerTokenRoundTripper wraps a RoundTripper to add Bearer token authentication
+// bearerTokenRoundTripper wraps a RoundTripper to add Bearer token authentication.
+// It re-reads the token from disk when the cached copy is older than ttl,
+// so rotated ServiceAccount tokens are picked up without restarting the process.
 type bearerTokenRoundTripper struct {
-	bearerToken string
-	rt          http.RoundTripper
+	tokenFile string
+	ttl       time.Duration
+	rt        http.RoundTripper
+
+	mu       sync.Mutex
+	token    string
+	lastRead time.Time
 }
 
 func (b *bearerTokenRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
-	if b.bearerToken != "" {
-		req.Header.Set("Authorization", "Bearer "+b.bearerToken)
+	token, err := b.currentToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bearer token file %s: %w", b.tokenFile, err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
 	}
 	return b.rt.RoundTrip(req)
 }
 
-func NewPrometheusClient(url string, insecureSkipVerify bool, bearerTokenFile string) (clients.MetricsClient, error) {
-	skipVerify := false
+// currentToken returns the cached token, refreshing it from disk first if the
+// cache is older than ttl.
+func (b *bearerTokenRoundTripper) currentToken() (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.token == "" || time.Since(b.lastRead) >= b.ttl {
+		contents, err := os.ReadFile(b.tokenFile)
+		if err != nil {
+			return "", err
+		}
+		b.token = string(contents)
+		b.lastRead = time.Now()
+	}
+	return b.token, nil
+}
+
+// clientCertReloader supplies tls.Config.GetClientCertificate, reloading the
+// keypair from disk so a cert rotated on disk (e.g. by a CSR-renewal
+// sidecar) is presented on the next TLS handshake without a restart.
+type clientCertReloader struct {
+	certFile string
+	keyFile  string
+	ttl      time.Duration
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	lastRead time.Time
+}
+
+func (r *clientCertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cert == nil || time.Since(r.lastRead) >= r.ttl {
+		cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+		if err != nil {
+			return nil, err
+		}
+		r.cert = &cert
+		r.lastRead = time.Now()
+	}
+	return r.cert, nil
+}
+
+// splitURLs parses a comma-separated --metrics-client-url value into its
+// individual endpoints, trimming whitespace and dropping empty entries.
+func splitURLs(raw string) []string {
+	var urls []string
+	for _, u := range strings.Split(raw, ",") {
+		u = strings.TrimSpace(u)
+		if u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+func anyHTTPS(urls []string) bool {
+	for _, u := range urls {
+		if len(u) >= 8 && u[:8] == "https://" {
+			return true
+		}
+	}
+	return false
+}
+
+func NewPrometheusClient(cfg Config) (clients.MetricsClient, error) {
+	urls := splitURLs(cfg.URL)
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("no metrics client URL configured")
+	}
+
+	name := cfg.ClientName
+	if name == "" {
+		name = defaultClientName
+	}
+
+	tlsConfig, err := cfg.TLSProfile.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
 	// Ignore TLS errors by setting InsecureSkipVerify to true
 	// This requires using a custom RoundTripper
 	// See: https://pkg.go.dev/github.com/prometheus/client_golang/api#Config
 	// and https://pkg.go.dev/net/http#Transport
-	if insecureSkipVerify && len(url) >= 8 && url[:8] == "https://" {
-		skipVerify = true
+	if cfg.InsecureSkipVerify && anyHTTPS(urls) {
+		tlsConfig.InsecureSkipVerify = true
 		logger.Logger.Warn("InsecureSkipVerify is enabled. TLS certificate verification will be skipped.")
 	}
 
-	// Create base transport with TLS configuration
+	if cfg.ServerName != "" {
+		tlsConfig.ServerName = cfg.ServerName
+	}
+
+	if cfg.CAFile != "" {
+		caCert, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file %s: %w", cfg.CAFile, err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("failed to parse CA file %s: no valid certificates found", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	var certReloader *clientCertReloader
+	if cfg.ClientCertFile != "" || cfg.ClientKeyFile != "" {
+		if cfg.ClientCertFile == "" || cfg.ClientKeyFile == "" {
+			return nil, fmt.Errorf("both ClientCertFile and ClientKeyFile must be set to use mTLS authentication")
+		}
+		if _, err := tls.LoadX509KeyPair(cfg.ClientCertFile, cfg.ClientKeyFile); err != nil {
+			return nil, fmt.Errorf("failed to load client keypair (%s, %s): %w", cfg.ClientCertFile, cfg.ClientKeyFile, err)
+		}
+		logger.Logger.Info("Using mTLS client-certificate authentication for Prometheus")
+		certReloader = &clientCertReloader{
+			certFile: cfg.ClientCertFile,
+			keyFile:  cfg.ClientKeyFile,
+			ttl:      cfg.CertReloadInterval,
+		}
+		tlsConfig.GetClientCertificate = certReloader.GetClientCertificate
+	}
+
+	// Create base transport with TLS configuration. Keep-alives are disabled
+	// when mTLS is in use so every request performs a fresh handshake,
+	// ensuring a rotated client certificate is picked up promptly.
 	transport := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: skipVerify},
+		TLSClientConfig:   tlsConfig,
+		DisableKeepAlives: certReloader != nil,
 	}
 
 	// Wrap with bearer token authentication if token file is provided
 	var roundTripper http.RoundTripper = transport
-	if bearerTokenFile != "" {
-		token, err := os.ReadFile(bearerTokenFile)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read bearer token file %s: %w", bearerTokenFile, err)
+	var bearerTokenRT *bearerTokenRoundTripper
+	if cfg.BearerTokenFile != "" {
+		if _, err := os.ReadFile(cfg.BearerTokenFile); err != nil {
+			return nil, fmt.Errorf("failed to read bearer token file %s: %w", cfg.BearerTokenFile, err)
 		}
 		logger.Logger.Info("Using bearer token authentication for Prometheus")
-		roundTripper = &bearerTokenRoundTripper{
-			bearerToken: string(token),
-			rt:          transport,
+		ttl := cfg.BearerTokenTTL
+		if ttl == 0 {
+			ttl = defaultBearerTokenTTL
+		}
+		bearerTokenRT = &bearerTokenRoundTripper{
+			tokenFile: cfg.BearerTokenFile,
+			ttl:       ttl,
+			rt:        transport,
 		}
+		roundTripper = bearerTokenRT
 	}
 
-	client, err := prometheusApi.NewClient(prometheusApi.Config{
-		Address:      url,
-		RoundTripper: roundTripper,
-	})
-	if err != nil {
-		return nil, err
+	if len(cfg.ExtraQueryParams) > 0 {
+		roundTripper = &queryParamRoundTripper{params: cfg.ExtraQueryParams, rt: roundTripper}
 	}
-	v1api := prometheusv1.NewAPI(client)
+
+	endpoints := make([]*endpoint, 0, len(urls))
+	for _, u := range urls {
+		client, err := prometheusApi.NewClient(prometheusApi.Config{
+			Address:      u,
+			RoundTripper: roundTripper,
+		})
+		if err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, &endpoint{url: u, api: prometheusv1.NewAPI(client), healthy: 1})
+		telemetry.MetricsEndpointHealthy.WithLabelValues(name, u).Set(1)
+	}
+
 	return &PrometheusClient{
-		prometheusAPI: v1api,
+		endpoints:          endpoints,
+		clientName:         name,
+		bearerTokenRT:      bearerTokenRT,
+		clientCertReloader: certReloader,
 	}, nil
 }
 
@@ -86,11 +402,13 @@ func (c *PrometheusClient) FetchPVCsMetrics(ctx context.Context, when time.Time)
 
 	usedBytes, err := c.getMetricValues(ctx, usedBytesQuery, when)
 	if err != nil {
+		telemetry.MetricsFetchErrorsTotal.WithLabelValues(c.clientName).Inc()
 		return nil, err
 	}
 
 	capacityBytes, err := c.getMetricValues(ctx, capacityBytesQuery, when)
 	if err != nil {
+		telemetry.MetricsFetchErrorsTotal.WithLabelValues(c.clientName).Inc()
 		return nil, err
 	}
 
@@ -103,15 +421,24 @@ func (c *PrometheusClient) FetchPVCsMetrics(ctx context.Context, when time.Time)
 		}
 
 		volumeStats[key] = pvcMetrics
+		if pvcMetrics.VolumeCapacityBytes > 0 {
+			telemetry.PVCUsageRatio.WithLabelValues(key.Namespace, key.Name).Set(float64(pvcMetrics.VolumeUsedBytes) / float64(pvcMetrics.VolumeCapacityBytes))
+		}
 	}
 	return volumeStats, nil
 }
 
-func (c *PrometheusClient) getMetricValues(ctx context.Context, query string, time time.Time) (map[types.NamespacedName]int64, error) {
-	res, _, err := c.prometheusAPI.Query(ctx, query, time)
+// getMetricValues queries the configured endpoints in round-robin order,
+// starting from a different endpoint on each call. On a 5xx or transport
+// error it retries against the next endpoint with exponential backoff,
+// giving up once every endpoint currently marked healthy (or, if none is,
+// every endpoint) has been tried once.
+func (c *PrometheusClient) getMetricValues(ctx context.Context, query string, queryTime time.Time) (map[types.NamespacedName]int64, error) {
+	res, ep, err := c.query(ctx, query, queryTime)
 	if err != nil {
 		return nil, err
 	}
+	telemetry.MetricsEndpointHealthy.WithLabelValues(c.clientName, ep).Set(1)
 
 	if res.Type() != model.ValVector {
 		return nil, fmt.Errorf("unknown response type: %s", res.Type().String())
@@ -127,3 +454,79 @@ func (c *PrometheusClient) getMetricValues(ctx context.Context, query string, ti
 	}
 	return resultMap, nil
 }
+
+// query runs query against the configured endpoints, starting at the next
+// one in round-robin order (advancing by exactly 1 per call, regardless of
+// how many endpoints a failover visits) and failing over to subsequent
+// endpoints on a retryable error. Endpoints that failed their last query
+// are skipped in favor of healthy ones, so a known-bad endpoint isn't
+// retried on every single call; they're only retried once every endpoint
+// is unhealthy. It returns the URL of the endpoint that answered, or the
+// last error seen once every endpoint in that order has been tried.
+func (c *PrometheusClient) query(ctx context.Context, query string, queryTime time.Time) (model.Value, string, error) {
+	base := int(atomic.AddUint64(&c.next, 1)-1) % len(c.endpoints)
+
+	// Try endpoints marked healthy first, in round-robin order starting at
+	// base, so a known-bad endpoint isn't retried on every single call.
+	// Only fall back to the unhealthy ones if every endpoint is currently
+	// marked unhealthy, so a recovered endpoint can still be found.
+	order := make([]int, 0, len(c.endpoints))
+	for i := 0; i < len(c.endpoints); i++ {
+		idx := (base + i) % len(c.endpoints)
+		if atomic.LoadInt32(&c.endpoints[idx].healthy) != 0 {
+			order = append(order, idx)
+		}
+	}
+	if len(order) == 0 {
+		for i := 0; i < len(c.endpoints); i++ {
+			order = append(order, (base+i)%len(c.endpoints))
+		}
+	}
+
+	delay := endpointRetryBaseDelay
+	var lastErr error
+	for i, idx := range order {
+		ep := c.endpoints[idx]
+
+		if i > 0 {
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return nil, "", ctx.Err()
+			}
+			delay *= 2
+		}
+
+		res, _, err := ep.api.Query(ctx, query, queryTime)
+		if err == nil {
+			atomic.StoreInt32(&ep.healthy, 1)
+			return res, ep.url, nil
+		}
+
+		lastErr = err
+		atomic.StoreInt32(&ep.healthy, 0)
+		telemetry.MetricsEndpointHealthy.WithLabelValues(c.clientName, ep.url).Set(0)
+		if !isRetryableQueryError(err) {
+			return nil, ep.url, err
+		}
+		logger.Logger.Warnf("query against metrics endpoint %s failed, trying next endpoint: %v", ep.url, err)
+	}
+	return nil, "", lastErr
+}
+
+// isRetryableQueryError reports whether a query error looks like a
+// transient server-side or transport failure worth retrying against
+// another endpoint, as opposed to e.g. a malformed query.
+func isRetryableQueryError(err error) bool {
+	var apiErr *prometheusv1.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Type == prometheusv1.ErrServer || apiErr.Type == prometheusv1.ErrTimeout
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
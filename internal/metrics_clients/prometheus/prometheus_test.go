@@ -2,11 +2,21 @@ package prometheus
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"errors"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -34,7 +44,7 @@ func TestGetMetricValues(t *testing.T) {
 		defer ts.Close()
 
 		// If 404 the client should be created
-		client, err := NewPrometheusClient(ts.URL, false, "")
+		client, err := NewPrometheusClient(Config{URL: ts.URL})
 		assert.NoError(t, err)
 
 		// but the metrics obviously cannot be fetched
@@ -50,7 +60,7 @@ func TestGetMetricValues(t *testing.T) {
 		mockAPI := NewMockAPI(ctrl)
 
 		client := &PrometheusClient{
-			prometheusAPI: mockAPI,
+			endpoints: []*endpoint{{url: "mock", api: mockAPI}},
 		}
 
 		mockReturn := prometheusmodel.Vector{
@@ -83,7 +93,7 @@ func TestGetMetricValues(t *testing.T) {
 		mockAPI := NewMockAPI(ctrl)
 
 		client := &PrometheusClient{
-			prometheusAPI: mockAPI,
+			endpoints: []*endpoint{{url: "mock", api: mockAPI}},
 		}
 
 		mockAPI.
@@ -107,7 +117,7 @@ func TestFetchPVCsMetrics(t *testing.T) {
 		mockAPI := NewMockAPI(ctrl)
 
 		client := &PrometheusClient{
-			prometheusAPI: mockAPI,
+			endpoints: []*endpoint{{url: "mock", api: mockAPI}},
 		}
 
 		mockUsedBytesQuery := prometheusmodel.Vector{
@@ -172,7 +182,7 @@ func TestBearerTokenAuthentication(t *testing.T) {
 		assert.NoError(t, err)
 
 		// Create client with bearer token
-		client, err := NewPrometheusClient(ts.URL, false, tokenFile)
+		client, err := NewPrometheusClient(Config{URL: ts.URL, BearerTokenFile: tokenFile})
 		assert.NoError(t, err)
 		assert.NotNil(t, client)
 
@@ -194,7 +204,7 @@ func TestBearerTokenAuthentication(t *testing.T) {
 		defer ts.Close()
 
 		// Create client without bearer token
-		client, err := NewPrometheusClient(ts.URL, false, "")
+		client, err := NewPrometheusClient(Config{URL: ts.URL})
 		assert.NoError(t, err)
 		assert.NotNil(t, client)
 
@@ -207,9 +217,275 @@ func TestBearerTokenAuthentication(t *testing.T) {
 
 	t.Run("invalid token file", func(t *testing.T) {
 		// Try to create a client with a non-existent token file
-		client, err := NewPrometheusClient("http://localhost:9090", false, "/non/existent/token/file")
+		client, err := NewPrometheusClient(Config{URL: "http://localhost:9090", BearerTokenFile: "/non/existent/token/file"})
 		assert.Error(t, err)
 		assert.Nil(t, client)
 		assert.Contains(t, err.Error(), "failed to read bearer token file")
 	})
+
+	t.Run("token is reloaded after rotation", func(t *testing.T) {
+		receivedToken := ""
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			receivedToken = r.Header.Get("Authorization")
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer ts.Close()
+
+		tmpDir := t.TempDir()
+		tokenFile := filepath.Join(tmpDir, "token")
+		oldToken := "old-token"
+		err := os.WriteFile(tokenFile, []byte(oldToken), 0600)
+		assert.NoError(t, err)
+
+		client, err := NewPrometheusClient(Config{URL: ts.URL, BearerTokenFile: tokenFile})
+		assert.NoError(t, err)
+
+		_, _ = client.FetchPVCsMetrics(context.TODO(), time.Time{})
+		assert.Equal(t, "Bearer "+oldToken, receivedToken)
+
+		// Force the cache to be considered stale, then rewrite the token file
+		// mid-flight to simulate a kubelet-driven rotation.
+		prometheusClient := client.(*PrometheusClient)
+		prometheusClient.bearerTokenRT.mu.Lock()
+		prometheusClient.bearerTokenRT.lastRead = time.Time{}
+		prometheusClient.bearerTokenRT.mu.Unlock()
+
+		newToken := "new-rotated-token"
+		err = os.WriteFile(tokenFile, []byte(newToken), 0600)
+		assert.NoError(t, err)
+
+		_, _ = client.FetchPVCsMetrics(context.TODO(), time.Time{})
+		assert.Equal(t, "Bearer "+newToken, receivedToken)
+	})
+}
+
+func TestTLSProfiles(t *testing.T) {
+	caPool, caCertPEM, serverCert := newSelfSignedTLSFixture(t)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	ts.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	ts.StartTLS()
+	defer ts.Close()
+
+	tmpDir := t.TempDir()
+	caFile := filepath.Join(tmpDir, "ca.pem")
+	assert.NoError(t, os.WriteFile(caFile, caCertPEM, 0600))
+
+	_ = caPool
+
+	t.Run("default profile trusts the CA", func(t *testing.T) {
+		client, err := NewPrometheusClient(Config{URL: ts.URL, TLSProfile: TLSProfileDefault, CAFile: caFile})
+		assert.NoError(t, err)
+
+		_, err = client.FetchPVCsMetrics(context.TODO(), time.Time{})
+		assert.Error(t, err) // the server isn't a real Prometheus, but the TLS handshake must succeed
+		assert.NotContains(t, err.Error(), "certificate")
+	})
+
+	t.Run("without the CA the handshake fails", func(t *testing.T) {
+		client, err := NewPrometheusClient(Config{URL: ts.URL, TLSProfile: TLSProfileDefault})
+		assert.NoError(t, err)
+
+		_, err = client.FetchPVCsMetrics(context.TODO(), time.Time{})
+		assert.Error(t, err)
+	})
+
+	t.Run("unknown profile is rejected", func(t *testing.T) {
+		client, err := NewPrometheusClient(Config{URL: ts.URL, TLSProfile: "bogus"})
+		assert.Error(t, err)
+		assert.Nil(t, client)
+	})
+}
+
+func TestMTLSClientCertificate(t *testing.T) {
+	var receivedSerial *big.Int
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) > 0 {
+			receivedSerial = r.TLS.PeerCertificates[0].SerialNumber
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	ts.StartTLS()
+	defer ts.Close()
+
+	tmpDir := t.TempDir()
+	certFile := filepath.Join(tmpDir, "client.crt")
+	keyFile := filepath.Join(tmpDir, "client.key")
+
+	certPEM, keyPEM := newSelfSignedClientCert(t, 10)
+	assert.NoError(t, os.WriteFile(certFile, certPEM, 0600))
+	assert.NoError(t, os.WriteFile(keyFile, keyPEM, 0600))
+
+	client, err := NewPrometheusClient(Config{
+		URL:                ts.URL,
+		TLSProfile:         TLSProfileDefault,
+		InsecureSkipVerify: true,
+		ClientCertFile:     certFile,
+		ClientKeyFile:      keyFile,
+	})
+	assert.NoError(t, err)
+
+	_, _ = client.FetchPVCsMetrics(context.TODO(), time.Time{})
+	assert.Equal(t, big.NewInt(10), receivedSerial)
+
+	// Rotate the certificate on disk and confirm the next handshake presents it.
+	newCertPEM, newKeyPEM := newSelfSignedClientCert(t, 20)
+	assert.NoError(t, os.WriteFile(certFile, newCertPEM, 0600))
+	assert.NoError(t, os.WriteFile(keyFile, newKeyPEM, 0600))
+
+	_, _ = client.FetchPVCsMetrics(context.TODO(), time.Time{})
+	assert.Equal(t, big.NewInt(20), receivedSerial)
+}
+
+const emptyVectorResponse = `{"status":"success","data":{"resultType":"vector","result":[]}}`
+
+func TestMultiEndpointRoundRobinAndFailover(t *testing.T) {
+	t.Run("round robins across healthy endpoints", func(t *testing.T) {
+		var hitsA, hitsB int32
+		serverA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&hitsA, 1)
+			w.Write([]byte(emptyVectorResponse))
+		}))
+		defer serverA.Close()
+		serverB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&hitsB, 1)
+			w.Write([]byte(emptyVectorResponse))
+		}))
+		defer serverB.Close()
+
+		client, err := NewPrometheusClient(Config{URL: serverA.URL + "," + serverB.URL})
+		assert.NoError(t, err)
+
+		for i := 0; i < 4; i++ {
+			_, err := client.FetchPVCsMetrics(context.TODO(), time.Time{})
+			assert.NoError(t, err)
+		}
+
+		assert.Equal(t, int32(4), atomic.LoadInt32(&hitsA))
+		assert.Equal(t, int32(4), atomic.LoadInt32(&hitsB))
+	})
+
+	t.Run("fails over to the next endpoint on a server error", func(t *testing.T) {
+		var hitsDown int32
+		down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&hitsDown, 1)
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer down.Close()
+
+		var hitsUp int32
+		up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&hitsUp, 1)
+			w.Write([]byte(emptyVectorResponse))
+		}))
+		defer up.Close()
+
+		client, err := NewPrometheusClient(Config{URL: down.URL + "," + up.URL})
+		assert.NoError(t, err)
+
+		_, err = client.FetchPVCsMetrics(context.TODO(), time.Time{})
+		assert.NoError(t, err)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&hitsDown))
+		assert.Equal(t, int32(2), atomic.LoadInt32(&hitsUp))
+	})
+
+	t.Run("returns an error once every endpoint has failed", func(t *testing.T) {
+		down1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer down1.Close()
+		down2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer down2.Close()
+
+		client, err := NewPrometheusClient(Config{URL: down1.URL + "," + down2.URL})
+		assert.NoError(t, err)
+
+		_, err = client.FetchPVCsMetrics(context.TODO(), time.Time{})
+		assert.Error(t, err)
+	})
+}
+
+// newSelfSignedClientCert generates a self-signed client certificate/key pair
+// PEM-encoded for use with --client-cert-file/--client-key-file, tagged with
+// serial so tests can tell which certificate a handshake presented.
+func newSelfSignedClientCert(t *testing.T, serial int64) (certPEM, keyPEM []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "pvc-autoscaler-test-client"},
+		NotBefore:    time.Now().Add(-1 * time.Hour),
+		NotAfter:     time.Now().Add(1 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM
+}
+
+// newSelfSignedTLSFixture generates a self-signed CA and a server certificate
+// issued by it, for exercising --ca-file against an httptest TLS server.
+func newSelfSignedTLSFixture(t *testing.T) (*x509.CertPool, []byte, tls.Certificate) {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "pvc-autoscaler-test-ca"},
+		NotBefore:             time.Now().Add(-1 * time.Hour),
+		NotAfter:              time.Now().Add(1 * time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	assert.NoError(t, err)
+	caCertPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+
+	caCert, err := x509.ParseCertificate(caDER)
+	assert.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+
+	serverKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	serverTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-1 * time.Hour),
+		NotAfter:     time.Now().Add(1 * time.Hour),
+		DNSNames:     []string{"127.0.0.1", "localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	serverDER, err := x509.CreateCertificate(rand.Reader, serverTemplate, caTemplate, &serverKey.PublicKey, caKey)
+	assert.NoError(t, err)
+
+	serverCert := tls.Certificate{
+		Certificate: [][]byte{serverDER, caDER},
+		PrivateKey:  serverKey,
+	}
+
+	return pool, caCertPEM, serverCert
 }
@@ -0,0 +1,27 @@
+// Package thanos adapts the Prometheus metrics client for use against a
+// Thanos Querier, which shares Prometheus's HTTP API but additionally
+// supports a partial_response query parameter: without it, Thanos will
+// silently return partial results when a StoreAPI is unreachable, which
+// would make PVC usage look lower than it really is.
+package thanos
+
+import (
+	clients "github.com/mkihr/pvc-autoscaler/internal/metrics_clients/clients"
+	"github.com/mkihr/pvc-autoscaler/internal/metrics_clients/prometheus"
+)
+
+// NewThanosClient builds a metrics client against a Thanos Querier endpoint,
+// reusing the Prometheus client's auth, TLS and HA-failover handling and
+// forcing partial_response=false so a down StoreAPI surfaces as an error
+// instead of an understated result.
+func NewThanosClient(cfg prometheus.Config) (clients.MetricsClient, error) {
+	params := make(map[string]string, len(cfg.ExtraQueryParams)+1)
+	for k, v := range cfg.ExtraQueryParams {
+		params[k] = v
+	}
+	params["partial_response"] = "false"
+	cfg.ExtraQueryParams = params
+	cfg.ClientName = "thanos"
+
+	return prometheus.NewPrometheusClient(cfg)
+}
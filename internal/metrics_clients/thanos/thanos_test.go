@@ -0,0 +1,45 @@
+package thanos
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mkihr/pvc-autoscaler/internal/metrics_clients/prometheus"
+	"github.com/mkihr/pvc-autoscaler/internal/telemetry"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewThanosClientSetsPartialResponseFalse(t *testing.T) {
+	var gotParam string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotParam = r.URL.Query().Get("partial_response")
+		w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[]}}`))
+	}))
+	defer ts.Close()
+
+	client, err := NewThanosClient(prometheus.Config{URL: ts.URL})
+	assert.NoError(t, err)
+
+	_, err = client.FetchPVCsMetrics(context.TODO(), time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, "false", gotParam)
+}
+
+func TestNewThanosClientLabelsTelemetryAsThanos(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[]}}`))
+	}))
+	defer ts.Close()
+
+	client, err := NewThanosClient(prometheus.Config{URL: ts.URL})
+	assert.NoError(t, err)
+
+	_, err = client.FetchPVCsMetrics(context.TODO(), time.Time{})
+	assert.NoError(t, err)
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(telemetry.MetricsEndpointHealthy.WithLabelValues("thanos", ts.URL)))
+}
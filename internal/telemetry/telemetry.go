@@ -0,0 +1,66 @@
+package telemetry
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	Registry = prometheus.NewRegistry()
+
+	ReconcileTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pvc_autoscaler_reconcile_total",
+		Help: "Total number of reconciliation runs, by result (success, error).",
+	}, []string{"result"})
+
+	ReconcileDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "pvc_autoscaler_reconcile_duration_seconds",
+		Help:    "Duration of reconciliation runs in seconds.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	MetricsFetchErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pvc_autoscaler_metrics_fetch_errors_total",
+		Help: "Total number of errors fetching PVC metrics, by metrics client.",
+	}, []string{"client"})
+
+	PVCUsageRatio = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pvc_autoscaler_pvc_usage_ratio",
+		Help: "Most recently observed ratio of used to capacity bytes for a PVC.",
+	}, []string{"namespace", "pvc"})
+
+	MetricsEndpointHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pvc_autoscaler_metrics_endpoint_healthy",
+		Help: "Whether the last query against a metrics endpoint succeeded (1) or not (0), by client and endpoint.",
+	}, []string{"client", "endpoint"})
+)
+
+func init() {
+	Registry.MustRegister(
+		ReconcileTotal,
+		ReconcileDuration,
+		MetricsFetchErrorsTotal,
+		PVCUsageRatio,
+		MetricsEndpointHealthy,
+	)
+}
+
+// NewServer returns an *http.Server exposing /metrics, /healthz and /readyz
+// on addr, ready to be started by the caller with ListenAndServe.
+func NewServer(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(Registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	return &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+}